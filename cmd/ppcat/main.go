@@ -3,15 +3,20 @@ package main
 import (
 	"bufio"
 	"encoding/hex"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/busoc/pdh"
+	"github.com/busoc/pdh/index"
 	"github.com/busoc/rt"
 	"github.com/midbel/cli"
 	"github.com/midbel/linewriter"
@@ -19,17 +24,17 @@ import (
 
 var commands = []*cli.Command{
 	{
-		Usage: "list [-i] [-g] <file...>",
+		Usage: "list [-i] [-g] [-c code] <file...>",
 		Short: "",
 		Run:   runList,
 	},
 	{
-		Usage: "diff [-i] [-g] <file...>",
+		Usage: "diff [-i] [-g] [-code code] <file...>",
 		Short: "",
 		Run:   runDiff,
 	},
 	{
-		Usage: "count [-g] <file...>",
+		Usage: "count [-g] [-c code] <file...>",
 		Short: "",
 		Run:   runCount,
 	},
@@ -38,6 +43,16 @@ var commands = []*cli.Command{
 		Short: "",
 		Run:   runTake,
 	},
+	{
+		Usage: "index build|verify|stat [options] <args...>",
+		Short: "",
+		Run:   runIndex,
+	},
+	{
+		Usage: "serve [-listen addr] [-proto udp|tcp] [-out dir] [-rotate interval] [-http addr] [-c catalog] [-origin byte]",
+		Short: "",
+		Run:   runServe,
+	},
 }
 
 const helpText = `{{.Name}} scan the HRDP archive to consolidate the USOC HRDP archive
@@ -81,28 +96,36 @@ func Line(csv bool) *linewriter.Writer {
 func runList(cmd *cli.Command, args []string) error {
 	quiet := cmd.Flag.Bool("q", false, "quiet")
 	hrdp := cmd.Flag.Bool("a", false, "hrdp")
-	csv := cmd.Flag.Bool("c", false, "csv format")
+	format := cmd.Flag.String("f", "text", "output format (text, csv, json, msgpack)")
+	code := cmd.Flag.String("c", "", "restrict to a single code (hex), using the sidecar index when present")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	mr, err := rt.Browse(cmd.Flag.Args(), true)
+	rw, err := recordWriter(*format, os.Stdout)
 	if err != nil {
 		return err
 	}
-	defer mr.Close()
-	d := pdh.NewDecoder(rt.NewReader(mr), nil)
+	var codeBytes []byte
+	if *code != "" {
+		if codeBytes, err = decodeCode(*code); err != nil {
+			return err
+		}
+	}
 
 	var base uint16
 	if *hrdp {
 		base = pdh.UMIHeaderLen
 	}
 
-	line := Line(*csv)
+	line := Line(*format == "csv")
 	var z rt.Coze
-	for {
-		switch p, err := d.Decode(false); err {
-		case nil:
-			if !*quiet {
+	emit := func(p pdh.Packet) error {
+		if !*quiet {
+			if rw != nil {
+				if err := rw.WriteRecord(pdh.NewRecord(p)); err != nil {
+					return err
+				}
+			} else {
 				line.AppendTime(p.Timestamp(), rt.TimeFormat, linewriter.AlignCenter)
 				line.AppendString(p.State.String(), 8, linewriter.AlignRight)
 				line.AppendBytes(p.Code[:], 0, linewriter.Hex)
@@ -112,20 +135,70 @@ func runList(cmd *cli.Command, args []string) error {
 
 				io.Copy(os.Stdout, line)
 			}
-			z.EndTime = p.Timestamp()
-			if z.StartTime.IsZero() {
-				z.StartTime = z.EndTime
+		}
+		z.EndTime = p.Timestamp()
+		if z.StartTime.IsZero() {
+			z.StartTime = z.EndTime
+		}
+		z.Size += uint64(p.Len)
+		z.Count++
+		return nil
+	}
+
+	if ps, ok, err := tryIndexedRange(cmd.Flag.Args(), codeBytes); err != nil {
+		return err
+	} else if ok {
+		for _, p := range ps {
+			if err := emit(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var filter func(pdh.UMIHeader) (bool, error)
+	if len(codeBytes) > 0 {
+		filter = pdh.WithCodes([][]byte{codeBytes})
+	}
+	mr, err := rt.Browse(cmd.Flag.Args(), true)
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+	d := pdh.NewDecoder(rt.NewReader(mr), filter)
+	attachIndex(d, cmd.Flag.Args())
+
+	for {
+		switch p, err := d.Decode(false); err {
+		case nil:
+			if err := emit(p); err != nil {
+				return err
 			}
-			z.Size += uint64(p.Len)
-			z.Count++
 		case io.EOF:
-			// fmt.Printf("%d packets (%d)\n", z.Count, z.Size>>20)
 			return nil
 		default:
 			return err
 		}
 	}
-	return nil
+}
+
+// recordWriter resolves the -f flag shared by list and count: "json" and
+// "msgpack" are served by pdh's structured writers, while "text" and
+// "csv" are left to the caller's own linewriter-based layout.
+func recordWriter(format string, w io.Writer) (interface {
+	pdh.RecordWriter
+	pdh.CozeWriter
+}, error) {
+	switch format {
+	case "", "text", "csv":
+		return nil, nil
+	default:
+		rw, ok := pdh.NewRecordWriter(format, w)
+		if !ok {
+			return nil, fmt.Errorf("%s: unsupported format", format)
+		}
+		return rw, nil
+	}
 }
 
 type key struct {
@@ -135,24 +208,43 @@ type key struct {
 }
 
 func runCount(cmd *cli.Command, args []string) error {
-	csv := cmd.Flag.Bool("c", false, "csv format")
+	format := cmd.Flag.String("f", "text", "output format (text, csv, json, msgpack)")
 	interval := cmd.Flag.Duration("i", 0, "interval")
+	workers := cmd.Flag.Int("j", 1, "number of parallel decode workers")
+	code := cmd.Flag.String("c", "", "restrict to a single code (hex), using the sidecar index when present")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	mr, err := rt.Browse(cmd.Flag.Args(), true)
+	rw, err := recordWriter(*format, os.Stdout)
 	if err != nil {
 		return err
 	}
-	defer mr.Close()
-	d := pdh.NewDecoder(rt.NewReader(mr), nil)
+	var codeBytes []byte
+	if *code != "" {
+		if codeBytes, err = decodeCode(*code); err != nil {
+			return err
+		}
+	}
+	next, done, err := decodeSource(cmd.Flag.Args(), *workers, codeBytes)
+	if err != nil {
+		return err
+	}
+	defer done()
 
-	line := Line(*csv)
-	for cz := range countPackets(d, *interval) {
+	csv := *format == "csv"
+	line := Line(csv)
+	for cz := range countPackets(next, *interval) {
+		if rw != nil {
+			pc := pdh.Coze{Count: cz.Count, Size: cz.Size, StartTime: cz.StartTime, EndTime: cz.EndTime}
+			if err := rw.WriteCoze(cz.origin.Code, pc); err != nil {
+				return err
+			}
+			continue
+		}
 		line.AppendUint(uint64(cz.origin.Origin), 2, linewriter.Hex|linewriter.WithZero)
 		line.AppendBytes(cz.origin.Code[:], 12, linewriter.Hex)
 		line.AppendUint(cz.Count, 8, linewriter.AlignRight)
-		if *csv {
+		if csv {
 			line.AppendUint(cz.Size, 8, linewriter.AlignRight)
 		} else {
 			line.AppendSize(int64(cz.Size), 8, linewriter.AlignRight)
@@ -168,21 +260,29 @@ func runCount(cmd *cli.Command, args []string) error {
 func runDiff(cmd *cli.Command, args []string) error {
 	csv := cmd.Flag.Bool("c", false, "csv format")
 	duration := cmd.Flag.Duration("d", 0, "minimum duration between two packets")
+	workers := cmd.Flag.Int("j", 1, "number of parallel decode workers")
+	code := cmd.Flag.String("code", "", "restrict to a single code (hex), using the sidecar index when present")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	mr, err := rt.Browse(cmd.Flag.Args(), true)
+	var codeBytes []byte
+	if *code != "" {
+		var err error
+		if codeBytes, err = decodeCode(*code); err != nil {
+			return err
+		}
+	}
+	next, done, err := decodeSource(cmd.Flag.Args(), *workers, codeBytes)
 	if err != nil {
 		return err
 	}
-	defer mr.Close()
-	d := pdh.NewDecoder(rt.NewReader(mr), nil)
+	defer done()
 
 	line := Line(*csv)
 
 	stats := make(map[[pdh.UMICodeLen]byte]pdh.Packet)
 	for {
-		p, err := d.Decode(false)
+		p, err := next()
 		if err != nil {
 			if err == io.EOF {
 				break
@@ -221,7 +321,7 @@ type coze struct {
 	origin key
 }
 
-func countPackets(d *pdh.Decoder, i time.Duration) <-chan coze {
+func countPackets(next func() (pdh.Packet, error), i time.Duration) <-chan coze {
 	q := make(chan coze)
 	go func() {
 		defer close(q)
@@ -229,7 +329,7 @@ func countPackets(d *pdh.Decoder, i time.Duration) <-chan coze {
 		stats := make(map[key]rt.Coze)
 		keys := make(map[key]time.Time)
 		for {
-			p, err := d.Decode(false)
+			p, err := next()
 			if err != nil {
 				if err == io.EOF {
 					break
@@ -267,7 +367,191 @@ func countPackets(d *pdh.Decoder, i time.Duration) <-chan coze {
 }
 
 func runTake(cmd *cli.Command, args []string) error {
-	return fmt.Errorf("not yet implemented")
+	interval := cmd.Flag.Duration("d", 0, "rotation interval")
+	name := cmd.Flag.String("n", "", "name used to expand the pattern (defaults to the catalog name)")
+	var cat catalog
+	cmd.Flag.Var(&cat, "c", "catalog of codes")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	args = cmd.Flag.Args()
+	if len(args) < 2 {
+		return fmt.Errorf("missing pattern and/or file arguments")
+	}
+	pattern, files := args[0], args[1:]
+	if *name == "" {
+		*name = cat.String()
+	}
+
+	mr, err := rt.Browse(files, true)
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+
+	var origin byte
+	if strings.Contains(pattern, "%o") {
+		origin, _ = catalogOrigin(cat.Codes())
+	}
+	filter := func(h pdh.UMIHeader) (bool, error) {
+		if len(cat.Codes()) > 0 {
+			keep, err := pdh.WithCodes(cat.Codes())(h)
+			if err != nil || !keep {
+				return keep, err
+			}
+		}
+		return pdh.WithOrigin(origin)(h)
+	}
+	d := pdh.NewDecoder(rt.NewReader(mr), filter)
+
+	w := newTakeWriter(pattern, *name, origin, *interval)
+	defer w.Close()
+
+	stats := make(map[[pdh.UMICodeLen]byte]rt.Coze)
+	for {
+		p, err := d.Decode(true)
+		switch err {
+		case nil:
+			buf, err := p.Marshal()
+			if err != nil {
+				return err
+			}
+			if err := w.Write(p.Timestamp(), buf); err != nil {
+				return err
+			}
+			cz := stats[p.Code]
+			cz.Count++
+			cz.Size += uint64(p.Len)
+			cz.EndTime = p.Timestamp()
+			if cz.StartTime.IsZero() {
+				cz.StartTime = cz.EndTime
+			}
+			stats[p.Code] = cz
+		case io.EOF:
+			return writeTakeSummary(stats)
+		default:
+			return err
+		}
+	}
+}
+
+func writeTakeSummary(stats map[[pdh.UMICodeLen]byte]rt.Coze) error {
+	line := Line(false)
+	var total rt.Coze
+	for code, cz := range stats {
+		line.AppendBytes(code[:], 0, linewriter.Hex)
+		line.AppendUint(cz.Count, 8, linewriter.AlignRight)
+		line.AppendSize(int64(cz.Size), 8, linewriter.AlignRight)
+		line.AppendTime(cz.StartTime, rt.TimeFormat, linewriter.AlignRight)
+		line.AppendTime(cz.EndTime, rt.TimeFormat, linewriter.AlignRight)
+		io.Copy(os.Stdout, line)
+
+		total.Count += cz.Count
+		total.Size += cz.Size
+		if total.StartTime.IsZero() || cz.StartTime.Before(total.StartTime) {
+			total.StartTime = cz.StartTime
+		}
+		if cz.EndTime.After(total.EndTime) {
+			total.EndTime = cz.EndTime
+		}
+	}
+	line.AppendString("total", 12, linewriter.AlignRight)
+	line.AppendUint(total.Count, 8, linewriter.AlignRight)
+	line.AppendSize(int64(total.Size), 8, linewriter.AlignRight)
+	io.Copy(os.Stdout, line)
+	return nil
+}
+
+func catalogOrigin(codes [][]byte) (byte, bool) {
+	if len(codes) == 0 {
+		return 0, false
+	}
+	origin := codes[0][0]
+	for _, c := range codes[1:] {
+		if c[0] != origin {
+			return 0, false
+		}
+	}
+	return origin, true
+}
+
+// takeWriter writes packets to files produced by expanding a pattern with
+// the packet timestamp, rotating onto a new file every interval and
+// renaming each file into place only once it is fully written.
+type takeWriter struct {
+	pattern  string
+	name     string
+	origin   byte
+	interval time.Duration
+
+	inner *os.File
+	tmp   string
+	final string
+	curr  time.Time
+}
+
+func newTakeWriter(pattern, name string, origin byte, interval time.Duration) *takeWriter {
+	return &takeWriter{
+		pattern:  pattern,
+		name:     name,
+		origin:   origin,
+		interval: interval,
+	}
+}
+
+func (w *takeWriter) Write(t time.Time, buf []byte) error {
+	if err := w.rotate(t); err != nil {
+		return err
+	}
+	_, err := w.inner.Write(buf)
+	return err
+}
+
+func (w *takeWriter) rotate(t time.Time) error {
+	var curr time.Time
+	if w.interval > 0 {
+		curr = t.Truncate(w.interval)
+	}
+	if w.inner != nil && curr.Equal(w.curr) {
+		return nil
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	file := expandPattern(w.pattern, t, w.name, w.origin)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(file + ".tmp")
+	if err != nil {
+		return err
+	}
+	w.inner, w.tmp, w.final, w.curr = f, file+".tmp", file, curr
+	return nil
+}
+
+func (w *takeWriter) Close() error {
+	if w.inner == nil {
+		return nil
+	}
+	err := w.inner.Close()
+	w.inner = nil
+	if err != nil {
+		return err
+	}
+	return os.Rename(w.tmp, w.final)
+}
+
+func expandPattern(pattern string, t time.Time, name string, origin byte) string {
+	r := strings.NewReplacer(
+		"%Y", fmt.Sprintf("%04d", t.Year()),
+		"%m", fmt.Sprintf("%02d", t.Month()),
+		"%d", fmt.Sprintf("%02d", t.Day()),
+		"%H", fmt.Sprintf("%02d", t.Hour()),
+		"%n", name,
+		"%o", fmt.Sprintf("%02x", origin),
+	)
+	return r.Replace(pattern)
 }
 
 type catalog struct {
@@ -325,3 +609,426 @@ func decodeCode(v string) ([]byte, error) {
 	}
 	return hex.DecodeString(v)
 }
+
+// decodeOrigin parses the -origin flag: empty means "match any origin",
+// matching pdh.WithOrigin's own treatment of 0.
+func decodeOrigin(v string) (byte, error) {
+	if v == "" {
+		return 0, nil
+	}
+	bs, err := hex.DecodeString(v)
+	if err != nil || len(bs) != 1 {
+		return 0, fmt.Errorf("%s: invalid origin", v)
+	}
+	return bs[0], nil
+}
+
+const defaultIndexName = "index.bin"
+
+// attachIndex looks for a sidecar index next to the given files and, if
+// one is found, wires it into d so that callers wanting random access
+// (via Seek/DecodeRange) do not have to open it themselves.
+func attachIndex(d *pdh.Decoder, files []string) {
+	if len(files) == 0 {
+		return
+	}
+	dir := filepath.Dir(files[0])
+	path := filepath.Join(dir, defaultIndexName)
+	idx, err := index.Open(path)
+	if err != nil {
+		return
+	}
+	d.UseIndex(idx, dir)
+}
+
+// farFuture is past any timestamp a real UMI packet can carry (Coarse is a
+// uint32 number of seconds), used as the upper bound of a DecodeRange call
+// that wants every packet for a code.
+var farFuture = time.Unix(int64(^uint32(0)), 0)
+
+// tryIndexedRange looks for a sidecar index next to files and, if found,
+// resolves every packet for code directly through it instead of a linear
+// scan, skipping any file and any other code's records entirely. ok is
+// false with a nil error when no index is found, so callers fall back to
+// their normal decode loop.
+func tryIndexedRange(files []string, code []byte) (ps []pdh.Packet, ok bool, err error) {
+	if len(files) == 0 || len(code) == 0 {
+		return nil, false, nil
+	}
+	dir := filepath.Dir(files[0])
+	idx, err := index.Open(filepath.Join(dir, defaultIndexName))
+	if err != nil {
+		return nil, false, nil
+	}
+	var want [pdh.UMICodeLen]byte
+	copy(want[:], code)
+
+	d := pdh.NewDecoder(nil, nil)
+	d.UseIndex(idx, dir)
+	ps, err = d.DecodeRange(want, time.Time{}, farFuture)
+	if err != nil {
+		return nil, false, err
+	}
+	return ps, true, nil
+}
+
+// decodeSource picks the decode strategy for count and diff: the sidecar
+// index when present and code restricts to a single one, a single Decoder
+// over the whole archive when workers <= 1 (the default, strictly
+// ordered), or a pdh.ParallelDecoder sharding the files across workers
+// goroutines otherwise. Either way it returns a next() function yielding
+// packets in timestamp order, and a func to release whatever it opened.
+func decodeSource(files []string, workers int, code []byte) (next func() (pdh.Packet, error), done func(), err error) {
+	if ps, ok, err := tryIndexedRange(files, code); err != nil {
+		return nil, nil, err
+	} else if ok {
+		i := 0
+		next = func() (pdh.Packet, error) {
+			if i >= len(ps) {
+				return pdh.Packet{}, io.EOF
+			}
+			p := ps[i]
+			i++
+			return p, nil
+		}
+		return next, func() {}, nil
+	}
+
+	var filter func(pdh.UMIHeader) (bool, error)
+	if len(code) > 0 {
+		filter = pdh.WithCodes([][]byte{code})
+	}
+
+	if workers > 1 {
+		pd := pdh.NewParallelDecoder(files, openArchiveFile, filter, false, workers)
+		packets, errc := pd.Decode()
+		next = func() (pdh.Packet, error) {
+			p, ok := <-packets
+			if ok {
+				return p, nil
+			}
+			select {
+			case err := <-errc:
+				if err != nil {
+					return pdh.Packet{}, err
+				}
+			default:
+			}
+			return pdh.Packet{}, io.EOF
+		}
+		return next, func() {}, nil
+	}
+
+	mr, err := rt.Browse(files, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	d := pdh.NewDecoder(rt.NewReader(mr), filter)
+	attachIndex(d, files)
+	next = func() (pdh.Packet, error) {
+		return d.Decode(false)
+	}
+	return next, func() { mr.Close() }, nil
+}
+
+// openArchiveFile opens a single archive file the same way every other
+// command does, through rt.Browse/rt.NewReader, for use as a
+// ParallelDecoder worker's reader.
+func openArchiveFile(file string) (io.ReadCloser, error) {
+	mr, err := rt.Browse([]string{file}, true)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{rt.NewReader(mr), mr}, nil
+}
+
+func runIndex(cmd *cli.Command, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing index subcommand (build, verify or stat)")
+	}
+	sub, rest := args[0], args[1:]
+	switch sub {
+	case "build":
+		return runIndexBuild(rest)
+	case "verify":
+		return runIndexVerify(rest)
+	case "stat":
+		return runIndexStat(rest)
+	default:
+		return fmt.Errorf("%s: unknown index subcommand", sub)
+	}
+}
+
+func runIndexBuild(args []string) error {
+	set := flag.NewFlagSet("index build", flag.ExitOnError)
+	out := set.String("o", "", "index file (defaults to <dir>/"+defaultIndexName+")")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	files := set.Args()
+	if len(files) == 0 {
+		return fmt.Errorf("missing file arguments")
+	}
+
+	path := *out
+	if path == "" {
+		path = filepath.Join(filepath.Dir(files[0]), defaultIndexName)
+	}
+
+	iw := index.NewWriter()
+	for _, file := range files {
+		if err := indexFile(iw, file); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := iw.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// indexFile scans a single archive file and appends one index record per
+// packet found in it. Records are keyed by the byte range the packet
+// occupies in file, tracked with a counting reader wrapped around the
+// same rt.Reader used by the other commands.
+func indexFile(iw *index.Writer, file string) error {
+	mr, err := rt.Browse([]string{file}, true)
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+
+	cr := &countingReader{inner: rt.NewReader(mr)}
+	d := pdh.NewDecoder(cr, nil)
+	name := filepath.Base(file)
+	for {
+		before := cr.offset
+		p, err := d.Decode(false)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		length := cr.offset - before
+		if err := iw.Append(p.Code, p.Coarse, p.Fine, name, before, uint32(length)); err != nil {
+			return err
+		}
+	}
+}
+
+type countingReader struct {
+	inner  io.Reader
+	offset int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.inner.Read(p)
+	c.offset += int64(n)
+	return n, err
+}
+
+func runIndexVerify(args []string) error {
+	set := flag.NewFlagSet("index verify", flag.ExitOnError)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if set.NArg() != 1 {
+		return fmt.Errorf("missing index argument")
+	}
+	idx, err := index.Open(set.Arg(0))
+	if err != nil {
+		return err
+	}
+	return idx.Verify()
+}
+
+func runIndexStat(args []string) error {
+	set := flag.NewFlagSet("index stat", flag.ExitOnError)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if set.NArg() != 1 {
+		return fmt.Errorf("missing index argument")
+	}
+	idx, err := index.Open(set.Arg(0))
+	if err != nil {
+		return err
+	}
+	st := idx.Stat()
+	fmt.Printf("buckets: %d\n", st.Buckets)
+	fmt.Printf("files:   %d\n", st.Files)
+	fmt.Printf("records: %d\n", st.Records)
+	return nil
+}
+
+func runServe(cmd *cli.Command, args []string) error {
+	listen := cmd.Flag.String("listen", ":9165", "listen address")
+	proto := cmd.Flag.String("proto", "udp", "protocol (udp or tcp)")
+	out := cmd.Flag.String("out", ".", "output directory")
+	rotate := cmd.Flag.Duration("rotate", time.Hour, "rotation interval")
+	http_ := cmd.Flag.String("http", ":9166", "http address reporting per-code counters")
+	originFlag := cmd.Flag.String("origin", "", "restrict to a single origin byte (hex)")
+	var cat catalog
+	cmd.Flag.Var(&cat, "c", "catalog of codes")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	origin, err := decodeOrigin(*originFlag)
+	if err != nil {
+		return err
+	}
+
+	stats := newStatTracker()
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/stats", stats)
+		log.Println(http.ListenAndServe(*http_, mux))
+	}()
+
+	pattern := filepath.Join(*out, "%Y/%m/%d/%H.dat")
+	w := newTakeWriter(pattern, "serve", 0, *rotate)
+	defer w.Close()
+
+	filter := func(h pdh.UMIHeader) (bool, error) {
+		if len(cat.Codes()) == 0 {
+			return true, nil
+		}
+		keep, err := pdh.WithCodes(cat.Codes())(h)
+		if err != nil || !keep {
+			return keep, err
+		}
+		return pdh.WithOrigin(origin)(h)
+	}
+
+	switch *proto {
+	case "udp":
+		return serveUDP(*listen, filter, w, stats)
+	case "tcp":
+		return serveTCP(*listen, filter, w, stats)
+	default:
+		return fmt.Errorf("%s: unsupported protocol", *proto)
+	}
+}
+
+// serveUDP never tears down the listener over a single bad datagram: a
+// malformed header or an oversized packet is logged and dropped, not
+// fatal. The decode buffer is sized at MaxPacketLen so an oversized UMI
+// packet fails decoding with a clear error instead of being silently
+// truncated by the socket itself at the old 4096-byte BufferSize.
+func serveUDP(addr string, filter func(pdh.UMIHeader) (bool, error), w *takeWriter, stats *statTracker) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	d := pdh.NewDecoderSize(pdh.NewUDPConn(pc), filter, pdh.MaxPacketLen)
+	for {
+		if err := consumeOne(d, w, stats); err != nil {
+			log.Printf("serve: dropping bad packet: %s", err)
+		}
+	}
+}
+
+func serveTCP(addr string, filter func(pdh.UMIHeader) (bool, error), w *takeWriter, stats *statTracker) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func(c net.Conn) {
+			defer c.Close()
+			d := pdh.NewDecoderSize(pdh.NewTCPConn(c), filter, pdh.MaxPacketLen)
+			if err := consumeLive(d, w, stats); err != nil {
+				log.Printf("serve: dropping connection from %s: %s", c.RemoteAddr(), err)
+			}
+		}(conn)
+	}
+}
+
+// consumeOne decodes and records exactly one packet off d.
+func consumeOne(d *pdh.Decoder, w *takeWriter, stats *statTracker) error {
+	p, err := d.Decode(true)
+	if err != nil {
+		return err
+	}
+	buf, err := p.Marshal()
+	if err != nil {
+		return nil
+	}
+	if err := w.Write(p.Timestamp(), buf); err != nil {
+		return err
+	}
+	stats.Observe(p)
+	return nil
+}
+
+// consumeLive decodes packets off d until it returns an error, writing
+// each one through w and recording it in stats. The caller treats the
+// returned error as "this connection is done", not "the listener is down".
+func consumeLive(d *pdh.Decoder, w *takeWriter, stats *statTracker) error {
+	for {
+		if err := consumeOne(d, w, stats); err != nil {
+			return err
+		}
+	}
+}
+
+// statTracker aggregates per-code rt.Coze counters the same way
+// countPackets does, but incrementally as packets arrive on a live
+// connection instead of once a file has been fully scanned.
+type statTracker struct {
+	mu    sync.Mutex
+	stats map[[pdh.UMICodeLen]byte]rt.Coze
+}
+
+func newStatTracker() *statTracker {
+	return &statTracker{stats: make(map[[pdh.UMICodeLen]byte]rt.Coze)}
+}
+
+func (s *statTracker) Observe(p pdh.Packet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cz := s.stats[p.Code]
+	cz.Count++
+	cz.Size += uint64(p.Len)
+	cz.EndTime = p.Timestamp()
+	if cz.StartTime.IsZero() {
+		cz.StartTime = cz.EndTime
+	}
+	s.stats[p.Code] = cz
+}
+
+func (s *statTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	line := Line(false)
+	for code, cz := range s.stats {
+		line.AppendBytes(code[:], 0, linewriter.Hex)
+		line.AppendUint(cz.Count, 8, linewriter.AlignRight)
+		line.AppendSize(int64(cz.Size), 8, linewriter.AlignRight)
+		line.AppendTime(cz.StartTime, rt.TimeFormat, linewriter.AlignRight)
+		line.AppendTime(cz.EndTime, rt.TimeFormat, linewriter.AlignRight)
+		io.Copy(w, line)
+	}
+}
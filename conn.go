@@ -0,0 +1,63 @@
+package pdh
+
+import (
+	"io"
+	"net"
+)
+
+// PacketConn turns a live UMI stream, UDP or TCP, into an io.Reader where
+// every Read call returns one full packet. UDP gets this for free (one
+// packet per datagram); TCP frames the stream itself using the Len field
+// carried in every header.
+type PacketConn struct {
+	udp net.PacketConn
+	tcp net.Conn
+	hdr []byte
+}
+
+// NewUDPConn wraps a bound UDP socket. Every datagram is expected to
+// hold exactly one UMI packet.
+func NewUDPConn(c net.PacketConn) *PacketConn {
+	return &PacketConn{udp: c}
+}
+
+// NewTCPConn wraps an accepted TCP connection and reframes the
+// length-prefixed UMI packets carried over it.
+func NewTCPConn(c net.Conn) *PacketConn {
+	return &PacketConn{tcp: c, hdr: make([]byte, UMIHeaderLen)}
+}
+
+func (c *PacketConn) Read(buf []byte) (int, error) {
+	if c.udp != nil {
+		n, _, err := c.udp.ReadFrom(buf)
+		return n, err
+	}
+	return c.readTCP(buf)
+}
+
+func (c *PacketConn) readTCP(buf []byte) (int, error) {
+	if _, err := io.ReadFull(c.tcp, c.hdr); err != nil {
+		return 0, err
+	}
+	h, err := decodeHeader(c.hdr)
+	if err != nil {
+		return 0, err
+	}
+	total := UMIHeaderLen + int(h.Len)
+	if total > len(buf) {
+		return 0, io.ErrShortBuffer
+	}
+	copy(buf, c.hdr)
+	if _, err := io.ReadFull(c.tcp, buf[UMIHeaderLen:total]); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// Close releases the underlying socket.
+func (c *PacketConn) Close() error {
+	if c.udp != nil {
+		return c.udp.Close()
+	}
+	return c.tcp.Close()
+}
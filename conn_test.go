@@ -0,0 +1,58 @@
+package pdh
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestPacketConnReadTCP(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	h := UMIHeader{Coarse: 42, Len: 4}
+	payload := []byte("data")
+	frame := append(encodeHeader(h), payload...)
+
+	go client.Write(frame)
+
+	c := NewTCPConn(server)
+	buf := make([]byte, MaxPacketLen)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	if n != len(frame) {
+		t.Fatalf("read: want %d bytes, got %d", len(frame), n)
+	}
+
+	got, err := decodeHeader(buf[:UMIHeaderLen])
+	if err != nil {
+		t.Fatalf("decodeHeader: %s", err)
+	}
+	if got.Coarse != h.Coarse || got.Len != h.Len {
+		t.Fatalf("decodeHeader: got %+v", got)
+	}
+	if !bytes.Equal(buf[UMIHeaderLen:n], payload) {
+		t.Fatalf("payload: got %q, want %q", buf[UMIHeaderLen:n], payload)
+	}
+}
+
+func TestPacketConnReadTCPShortBuffer(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	h := UMIHeader{Coarse: 1, Len: 10}
+	frame := append(encodeHeader(h), make([]byte, 10)...)
+
+	go client.Write(frame)
+
+	c := NewTCPConn(server)
+	buf := make([]byte, UMIHeaderLen+4) // too small for the 10-byte payload
+	if _, err := c.Read(buf); err != io.ErrShortBuffer {
+		t.Fatalf("want io.ErrShortBuffer, got %v", err)
+	}
+}
@@ -0,0 +1,392 @@
+// Package index builds and queries a sidecar index mapping a packet's
+// code and timestamp to its location in an HRDP archive.
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/busoc/timutil"
+)
+
+const CodeLen = 6
+
+var (
+	ErrNotFound        = errors.New("index: code not found")
+	ErrCorrupted       = errors.New("index: corrupted file")
+	magic              = [4]byte{'P', 'I', 'D', 'X'}
+	version      uint8 = 1
+)
+
+// recordLen is the on-disk size of a single Record: 6 (code) + 4 (coarse)
+// + 1 (fine) + 2 (file) + 8 (offset) + 4 (len) + 7 (reserved).
+const recordLen = 32
+
+// Record maps a packet's code and timestamp to its location (file and
+// byte range) in the archive.
+type Record struct {
+	Code   [CodeLen]byte
+	Coarse uint32
+	Fine   uint8
+	File   uint16
+	Offset uint64
+	Len    uint32
+}
+
+func (r Record) Timestamp() time.Time {
+	return timutil.Join5(r.Coarse, r.Fine)
+}
+
+func (r Record) before(o Record) bool {
+	if r.Coarse != o.Coarse {
+		return r.Coarse < o.Coarse
+	}
+	return r.Fine < o.Fine
+}
+
+func encodeRecord(r Record) []byte {
+	buf := make([]byte, recordLen)
+	copy(buf[0:], r.Code[:])
+	binary.BigEndian.PutUint32(buf[6:], r.Coarse)
+	buf[10] = r.Fine
+	binary.BigEndian.PutUint16(buf[11:], r.File)
+	binary.BigEndian.PutUint64(buf[13:], r.Offset)
+	binary.BigEndian.PutUint32(buf[21:], r.Len)
+	return buf
+}
+
+func decodeRecord(buf []byte) Record {
+	var r Record
+	copy(r.Code[:], buf[0:])
+	r.Coarse = binary.BigEndian.Uint32(buf[6:])
+	r.Fine = buf[10]
+	r.File = binary.BigEndian.Uint16(buf[11:])
+	r.Offset = binary.BigEndian.Uint64(buf[13:])
+	r.Len = binary.BigEndian.Uint32(buf[21:])
+	return r
+}
+
+// Entry is the resolved counterpart of a Record: the file name has
+// already been looked up in the index's file table.
+type Entry struct {
+	File   string
+	Offset int64
+	Len    uint32
+}
+
+func hashCode(code [CodeLen]byte) uint32 {
+	h := fnv.New32a()
+	h.Write(code[:])
+	return h.Sum32()
+}
+
+// Writer accumulates records while an archive is scanned and lays them
+// out on disk, via WriteTo, as a bucketed hash table keyed by Code.
+type Writer struct {
+	files   []string
+	fileIdx map[string]uint16
+	records []Record
+}
+
+func NewWriter() *Writer {
+	return &Writer{fileIdx: make(map[string]uint16)}
+}
+
+func (w *Writer) fileID(file string) uint16 {
+	if id, ok := w.fileIdx[file]; ok {
+		return id
+	}
+	id := uint16(len(w.files))
+	w.files = append(w.files, file)
+	w.fileIdx[file] = id
+	return id
+}
+
+// Append records the location of one packet.
+func (w *Writer) Append(code [CodeLen]byte, coarse uint32, fine uint8, file string, offset int64, length uint32) error {
+	if len(w.files) >= 1<<16 {
+		return fmt.Errorf("index: too many files (max %d)", 1<<16)
+	}
+	r := Record{
+		Code:   code,
+		Coarse: coarse,
+		Fine:   fine,
+		File:   w.fileID(file),
+		Offset: uint64(offset),
+		Len:    length,
+	}
+	w.records = append(w.records, r)
+	return nil
+}
+
+func bucketCount(records int) uint32 {
+	n := uint32(1)
+	for int(n) < records && n < 1<<20 {
+		n <<= 1
+	}
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+// WriteTo writes the finalized index to w. The layout is:
+//
+//	header: magic[4] version[1] buckets[4] files[4] records[4]
+//	file table: files entries of len[2] + name bytes
+//	bucket directory: buckets entries of offset[4] + count[4]
+//	records: records entries of recordLen bytes, grouped by bucket and
+//	  sorted by timestamp inside each bucket
+func (w *Writer) WriteTo(dst io.Writer) (int64, error) {
+	nbuckets := bucketCount(len(w.records))
+	mask := nbuckets - 1
+
+	buckets := make([][]Record, nbuckets)
+	for _, r := range w.records {
+		b := hashCode(r.Code) & mask
+		buckets[b] = append(buckets[b], r)
+	}
+	for _, b := range buckets {
+		sort.Slice(b, func(i, j int) bool { return b[i].before(b[j]) })
+	}
+
+	bw := bufio.NewWriter(dst)
+	var written int64
+
+	n, err := bw.Write(magic[:])
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+	if err := bw.WriteByte(version); err != nil {
+		return written, err
+	}
+	written++
+
+	var tmp [4]byte
+	binary.BigEndian.PutUint32(tmp[:], nbuckets)
+	if n, err := bw.Write(tmp[:]); err != nil {
+		return written, err
+	} else {
+		written += int64(n)
+	}
+	binary.BigEndian.PutUint32(tmp[:], uint32(len(w.files)))
+	if n, err := bw.Write(tmp[:]); err != nil {
+		return written, err
+	} else {
+		written += int64(n)
+	}
+	binary.BigEndian.PutUint32(tmp[:], uint32(len(w.records)))
+	if n, err := bw.Write(tmp[:]); err != nil {
+		return written, err
+	} else {
+		written += int64(n)
+	}
+
+	for _, f := range w.files {
+		var lb [2]byte
+		binary.BigEndian.PutUint16(lb[:], uint16(len(f)))
+		if n, err := bw.Write(lb[:]); err != nil {
+			return written, err
+		} else {
+			written += int64(n)
+		}
+		if n, err := bw.WriteString(f); err != nil {
+			return written, err
+		} else {
+			written += int64(n)
+		}
+	}
+
+	var offset uint32
+	for _, b := range buckets {
+		var entry [8]byte
+		binary.BigEndian.PutUint32(entry[0:], offset)
+		binary.BigEndian.PutUint32(entry[4:], uint32(len(b)))
+		if n, err := bw.Write(entry[:]); err != nil {
+			return written, err
+		} else {
+			written += int64(n)
+		}
+		offset += uint32(len(b))
+	}
+
+	for _, b := range buckets {
+		for _, r := range b {
+			if n, err := bw.Write(encodeRecord(r)); err != nil {
+				return written, err
+			} else {
+				written += int64(n)
+			}
+		}
+	}
+
+	return written, bw.Flush()
+}
+
+// Index is a read-only, in-memory view of a sidecar index file.
+type Index struct {
+	files   []string
+	buckets []bucket
+	records []Record
+	mask    uint32
+}
+
+type bucket struct {
+	offset uint32
+	count  uint32
+}
+
+// Open reads and parses the index file at path.
+func Open(path string) (*Index, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(buf)
+}
+
+// Parse decodes an index previously produced by Writer.WriteTo.
+func Parse(buf []byte) (*Index, error) {
+	if len(buf) < 17 || string(buf[0:4]) != string(magic[:]) {
+		return nil, ErrCorrupted
+	}
+	if buf[4] != version {
+		return nil, fmt.Errorf("index: unsupported version %d", buf[4])
+	}
+	nbuckets := binary.BigEndian.Uint32(buf[5:])
+	nfiles := binary.BigEndian.Uint32(buf[9:])
+	nrecords := binary.BigEndian.Uint32(buf[13:])
+
+	off := 17
+	files := make([]string, nfiles)
+	for i := range files {
+		if off+2 > len(buf) {
+			return nil, ErrCorrupted
+		}
+		l := int(binary.BigEndian.Uint16(buf[off:]))
+		off += 2
+		if off+l > len(buf) {
+			return nil, ErrCorrupted
+		}
+		files[i] = string(buf[off : off+l])
+		off += l
+	}
+
+	buckets := make([]bucket, nbuckets)
+	for i := range buckets {
+		if off+8 > len(buf) {
+			return nil, ErrCorrupted
+		}
+		buckets[i] = bucket{
+			offset: binary.BigEndian.Uint32(buf[off:]),
+			count:  binary.BigEndian.Uint32(buf[off+4:]),
+		}
+		off += 8
+	}
+
+	records := make([]Record, nrecords)
+	for i := range records {
+		if off+recordLen > len(buf) {
+			return nil, ErrCorrupted
+		}
+		records[i] = decodeRecord(buf[off:])
+		off += recordLen
+	}
+
+	return &Index{
+		files:   files,
+		buckets: buckets,
+		records: records,
+		mask:    nbuckets - 1,
+	}, nil
+}
+
+func (ix *Index) entry(r Record) Entry {
+	return Entry{
+		File:   ix.files[r.File],
+		Offset: int64(r.Offset),
+		Len:    r.Len,
+	}
+}
+
+func (ix *Index) bucketFor(code [CodeLen]byte) bucket {
+	return ix.buckets[hashCode(code)&ix.mask]
+}
+
+// Lookup returns the entry for the first packet of code at or after t.
+func (ix *Index) Lookup(code [CodeLen]byte, t time.Time) (Entry, bool) {
+	b := ix.bucketFor(code)
+	recs := ix.records[b.offset : b.offset+b.count]
+	i := sort.Search(len(recs), func(i int) bool {
+		return !recs[i].Timestamp().Before(t)
+	})
+	for ; i < len(recs); i++ {
+		if recs[i].Code == code {
+			return ix.entry(recs[i]), true
+		}
+	}
+	return Entry{}, false
+}
+
+// Range returns every entry for code with a timestamp in [from, to].
+func (ix *Index) Range(code [CodeLen]byte, from, to time.Time) []Entry {
+	b := ix.bucketFor(code)
+	recs := ix.records[b.offset : b.offset+b.count]
+	i := sort.Search(len(recs), func(i int) bool {
+		return !recs[i].Timestamp().Before(from)
+	})
+	var es []Entry
+	for ; i < len(recs); i++ {
+		r := recs[i]
+		if r.Timestamp().After(to) {
+			break
+		}
+		if r.Code == code {
+			es = append(es, ix.entry(r))
+		}
+	}
+	return es
+}
+
+// Stat summarizes an index for reporting (ppcat index stat).
+type Stat struct {
+	Buckets int
+	Files   int
+	Records int
+}
+
+func (ix *Index) Stat() Stat {
+	return Stat{
+		Buckets: len(ix.buckets),
+		Files:   len(ix.files),
+		Records: len(ix.records),
+	}
+}
+
+// Verify checks that every bucket's record range is well formed and that
+// each bucket only holds records that hash back to it.
+func (ix *Index) Verify() error {
+	for i, b := range ix.buckets {
+		if uint64(b.offset)+uint64(b.count) > uint64(len(ix.records)) {
+			return fmt.Errorf("index: bucket %d: out of range record span", i)
+		}
+		recs := ix.records[b.offset : b.offset+b.count]
+		for j, r := range recs {
+			if int(hashCode(r.Code)&ix.mask) != i {
+				return fmt.Errorf("index: bucket %d: record %d hashes elsewhere", i, j)
+			}
+			if j > 0 && r.before(recs[j-1]) {
+				return fmt.Errorf("index: bucket %d: records out of order", i)
+			}
+		}
+	}
+	return nil
+}
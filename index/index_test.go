@@ -0,0 +1,71 @@
+package index
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/busoc/timutil"
+)
+
+func code(b byte) [CodeLen]byte {
+	var c [CodeLen]byte
+	for i := range c {
+		c[i] = b
+	}
+	return c
+}
+
+func TestWriterParseRoundTrip(t *testing.T) {
+	w := NewWriter()
+	codeA, codeB := code(0x01), code(0x02)
+
+	if err := w.Append(codeA, 100, 0, "a.dat", 0, 32); err != nil {
+		t.Fatalf("append: %s", err)
+	}
+	if err := w.Append(codeA, 200, 0, "a.dat", 32, 48); err != nil {
+		t.Fatalf("append: %s", err)
+	}
+	if err := w.Append(codeB, 150, 0, "b.dat", 0, 16); err != nil {
+		t.Fatalf("append: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+
+	ix, err := Parse(buf.Bytes())
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+	if err := ix.Verify(); err != nil {
+		t.Fatalf("verify: %s", err)
+	}
+
+	st := ix.Stat()
+	if st.Records != 3 || st.Files != 2 {
+		t.Fatalf("stat: got %+v", st)
+	}
+
+	e, ok := ix.Lookup(codeA, timutil.Join5(100, 0))
+	if !ok {
+		t.Fatalf("lookup: want entry for codeA at t=100")
+	}
+	if e.File != "a.dat" || e.Offset != 0 || e.Len != 32 {
+		t.Fatalf("lookup: got %+v", e)
+	}
+
+	e, ok = ix.Lookup(codeA, timutil.Join5(150, 0))
+	if !ok || e.Offset != 32 {
+		t.Fatalf("lookup: want the record at or after t=150, got %+v (ok=%v)", e, ok)
+	}
+
+	es := ix.Range(codeA, timutil.Join5(0, 0), timutil.Join5(1000, 0))
+	if len(es) != 2 {
+		t.Fatalf("range: want 2 entries for codeA, got %d", len(es))
+	}
+
+	if _, ok := ix.Lookup(code(0xff), timutil.Join5(0, 0)); ok {
+		t.Fatalf("lookup: want no entry for an absent code")
+	}
+}
@@ -0,0 +1,140 @@
+package pdh
+
+import (
+	"container/heap"
+	"io"
+)
+
+// ParallelDecoder decodes many files concurrently: one private Decoder per
+// worker goroutine, sharded by contiguous blocks of files so each worker's
+// stream stays internally time-ordered. The decoded packets are merged
+// back into a single timestamp-ordered channel through a k-way merge heap.
+type ParallelDecoder struct {
+	files  []string
+	open   func(file string) (io.ReadCloser, error)
+	filter func(UMIHeader) (bool, error)
+	data   bool
+	n      int
+}
+
+// NewParallelDecoder builds a ParallelDecoder over files, using open to
+// turn each file into a closeable reader (typically rt.NewReader wrapped
+// around rt.Browse of that single file) and n worker goroutines. data
+// controls whether each Packet carries its payload. n is clamped to
+// [1, len(files)].
+func NewParallelDecoder(files []string, open func(string) (io.ReadCloser, error), filter func(UMIHeader) (bool, error), data bool, n int) *ParallelDecoder {
+	if n < 1 {
+		n = 1
+	}
+	if n > len(files) && len(files) > 0 {
+		n = len(files)
+	}
+	return &ParallelDecoder{files: files, open: open, filter: filter, data: data, n: n}
+}
+
+// Decode starts the workers and returns a channel of packets in global
+// timestamp order, and a channel that carries at most one error raised
+// by any worker.
+func (pd *ParallelDecoder) Decode() (<-chan Packet, <-chan error) {
+	out := make(chan Packet)
+	errc := make(chan error, 1)
+
+	shards := splitFiles(pd.files, pd.n)
+	streams := make([]<-chan Packet, len(shards))
+	for i, files := range shards {
+		ch := make(chan Packet)
+		streams[i] = ch
+		go func(files []string, ch chan<- Packet) {
+			defer close(ch)
+			for _, file := range files {
+				if err := pd.decodeFile(file, ch); err != nil {
+					select {
+					case errc <- err:
+					default:
+					}
+					return
+				}
+			}
+		}(files, ch)
+	}
+
+	go func() {
+		defer close(out)
+		mergeTimestamped(streams, out)
+	}()
+
+	return out, errc
+}
+
+func (pd *ParallelDecoder) decodeFile(file string, ch chan<- Packet) error {
+	r, err := pd.open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	d := NewDecoder(r, pd.filter)
+	for {
+		p, err := d.Decode(pd.data)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		ch <- p
+	}
+}
+
+// splitFiles divides files into n contiguous, near-equal blocks, keeping
+// each shard's files in their original (chronological) order.
+func splitFiles(files []string, n int) [][]string {
+	shards := make([][]string, 0, n)
+	base, rem := len(files)/n, len(files)%n
+	for i, idx := 0, 0; i < n && idx < len(files); i++ {
+		size := base
+		if i < rem {
+			size++
+		}
+		shards = append(shards, files[idx:idx+size])
+		idx += size
+	}
+	return shards
+}
+
+type mergeItem struct {
+	p   Packet
+	idx int
+}
+
+type mergeHeap []mergeItem
+
+func (h mergeHeap) Len() int            { return len(h) }
+func (h mergeHeap) Less(i, j int) bool  { return h[i].p.Timestamp().Before(h[j].p.Timestamp()) }
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(mergeItem)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	*h = old[:n-1]
+	return it
+}
+
+// mergeTimestamped drains streams in timestamp order, assuming each one
+// is individually non-decreasing, and writes the result to out.
+func mergeTimestamped(streams []<-chan Packet, out chan<- Packet) {
+	h := &mergeHeap{}
+	for i, s := range streams {
+		if p, ok := <-s; ok {
+			heap.Push(h, mergeItem{p: p, idx: i})
+		}
+	}
+	for h.Len() > 0 {
+		it := heap.Pop(h).(mergeItem)
+		out <- it.p
+		if p, ok := <-streams[it.idx]; ok {
+			heap.Push(h, mergeItem{p: p, idx: it.idx})
+		}
+	}
+}
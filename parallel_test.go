@@ -0,0 +1,159 @@
+package pdh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"testing"
+)
+
+// packetQueueReader hands back one pre-framed packet per Read call,
+// mirroring the one-packet-per-Read contract a real archive reader gives
+// decodeFile.
+type packetQueueReader struct {
+	frames [][]byte
+	i      int
+}
+
+func (r *packetQueueReader) Read(p []byte) (int, error) {
+	if r.i >= len(r.frames) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.frames[r.i])
+	r.i++
+	return n, nil
+}
+
+func (r *packetQueueReader) Close() error { return nil }
+
+func mustMarshal(t *testing.T, coarse uint32, data string) []byte {
+	t.Helper()
+	p := Packet{
+		UMIHeader: UMIHeader{Coarse: coarse, Len: uint16(len(data))},
+		Data:      []byte(data),
+	}
+	buf, err := p.Marshal()
+	if err != nil {
+		t.Fatalf("marshal: %s", err)
+	}
+	return buf
+}
+
+func TestSplitFiles(t *testing.T) {
+	files := []string{"a", "b", "c", "d", "e"}
+	for _, n := range []int{1, 2, 3, 5, 8} {
+		shards := splitFiles(files, n)
+
+		var got []string
+		for _, s := range shards {
+			got = append(got, s...)
+		}
+		if len(got) != len(files) {
+			t.Fatalf("n=%d: want %d files total, got %d", n, len(files), len(got))
+		}
+		for i, f := range got {
+			if f != files[i] {
+				t.Fatalf("n=%d: shards out of original order: got %v", n, got)
+			}
+		}
+
+		min, max := len(files), 0
+		for _, s := range shards {
+			if len(s) < min {
+				min = len(s)
+			}
+			if len(s) > max {
+				max = len(s)
+			}
+		}
+		if max-min > 1 {
+			t.Fatalf("n=%d: shard sizes too uneven: %v", n, shards)
+		}
+	}
+}
+
+func TestMergeTimestamped(t *testing.T) {
+	mk := func(coarses ...uint32) <-chan Packet {
+		ch := make(chan Packet)
+		go func() {
+			defer close(ch)
+			for _, c := range coarses {
+				ch <- Packet{UMIHeader: UMIHeader{Coarse: c}}
+			}
+		}()
+		return ch
+	}
+	streams := []<-chan Packet{
+		mk(1, 4, 8),
+		mk(2, 3, 9),
+		mk(5, 6, 7),
+	}
+
+	out := make(chan Packet)
+	go func() {
+		defer close(out)
+		mergeTimestamped(streams, out)
+	}()
+
+	var coarses []uint32
+	for p := range out {
+		coarses = append(coarses, p.Coarse)
+	}
+	if len(coarses) != 9 {
+		t.Fatalf("want 9 packets, got %d: %v", len(coarses), coarses)
+	}
+	if !sort.SliceIsSorted(coarses, func(i, j int) bool { return coarses[i] < coarses[j] }) {
+		t.Fatalf("mergeTimestamped: output not time ordered: %v", coarses)
+	}
+}
+
+func TestParallelDecoderDecode(t *testing.T) {
+	files := map[string][][]byte{
+		"f1": {mustMarshal(t, 1, "a"), mustMarshal(t, 4, "b")},
+		"f2": {mustMarshal(t, 2, "c"), mustMarshal(t, 3, "d")},
+	}
+	open := func(name string) (io.ReadCloser, error) {
+		frames, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: no such file", name)
+		}
+		return &packetQueueReader{frames: frames}, nil
+	}
+
+	pd := NewParallelDecoder([]string{"f1", "f2"}, open, nil, true, 2)
+	out, errc := pd.Decode()
+
+	var coarses []uint32
+	for p := range out {
+		coarses = append(coarses, p.Coarse)
+	}
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	default:
+	}
+	if len(coarses) != 4 {
+		t.Fatalf("want 4 packets, got %d: %v", len(coarses), coarses)
+	}
+	if !sort.SliceIsSorted(coarses, func(i, j int) bool { return coarses[i] < coarses[j] }) {
+		t.Fatalf("not timestamp ordered: %v", coarses)
+	}
+}
+
+func TestParallelDecoderOpenError(t *testing.T) {
+	want := errors.New("boom")
+	open := func(name string) (io.ReadCloser, error) {
+		return nil, want
+	}
+
+	pd := NewParallelDecoder([]string{"missing"}, open, nil, false, 1)
+	out, errc := pd.Decode()
+	for range out {
+	}
+	if err := <-errc; err != want {
+		t.Fatalf("want %v, got %v", want, err)
+	}
+}
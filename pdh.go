@@ -6,14 +6,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/busoc/pdh/index"
 	"github.com/busoc/timutil"
 )
 
 var (
 	ErrEmpty   = errors.New("empty")
 	ErrMissing = errors.New("no bytes left in buffer")
+	ErrNoIndex = errors.New("no index attached to decoder")
 )
 
 const (
@@ -23,6 +27,10 @@ const (
 
 const BufferSize = 4096
 
+// MaxPacketLen is the largest a UMI packet can ever be: the header plus
+// the largest value Len (a uint16) can hold.
+const MaxPacketLen = UMIHeaderLen + (1<<16 - 1)
+
 func WithCodes(vs [][]byte) func(h UMIHeader) (bool, error) {
 	return func(u UMIHeader) (bool, error) {
 		for _, v := range vs {
@@ -47,18 +55,32 @@ type Decoder struct {
 	filter func(h UMIHeader) (bool, error)
 	inner  io.Reader
 	buffer []byte
+
+	index *index.Index
+	dir   string
 }
 
 func NewDecoder(r io.Reader, filter func(UMIHeader) (bool, error)) *Decoder {
+	return NewDecoderSize(r, filter, BufferSize)
+}
+
+// NewDecoderSize is like NewDecoder but lets the caller size the internal
+// read buffer. Live sources such as a UDP PacketConn need it sized at
+// MaxPacketLen: a datagram larger than the buffer is truncated by the
+// socket itself before Decoder ever sees it.
+func NewDecoderSize(r io.Reader, filter func(UMIHeader) (bool, error), size int) *Decoder {
 	if filter == nil {
 		filter = func(_ UMIHeader) (bool, error) {
 			return true, nil
 		}
 	}
+	if size < UMIHeaderLen {
+		size = UMIHeaderLen
+	}
 	return &Decoder{
 		filter: filter,
 		inner:  r,
-		buffer: make([]byte, BufferSize),
+		buffer: make([]byte, size),
 	}
 }
 
@@ -81,6 +103,58 @@ func (d *Decoder) Decode(data bool) (p Packet, err error) {
 	return
 }
 
+// UseIndex attaches a sidecar index to the decoder, enabling Seek and
+// DecodeRange. dir is the directory the archive files referenced by idx
+// live in.
+func (d *Decoder) UseIndex(idx *index.Index, dir string) {
+	d.index = idx
+	d.dir = dir
+}
+
+// Seek returns the first packet for code at or after t. It returns
+// ErrNoIndex if UseIndex was never called.
+func (d *Decoder) Seek(code [UMICodeLen]byte, t time.Time) (Packet, error) {
+	if d.index == nil {
+		return Packet{}, ErrNoIndex
+	}
+	e, ok := d.index.Lookup(code, t)
+	if !ok {
+		return Packet{}, io.EOF
+	}
+	return d.readEntry(e)
+}
+
+// DecodeRange returns every packet for code with a timestamp in [from, to].
+func (d *Decoder) DecodeRange(code [UMICodeLen]byte, from, to time.Time) ([]Packet, error) {
+	if d.index == nil {
+		return nil, ErrNoIndex
+	}
+	es := d.index.Range(code, from, to)
+	ps := make([]Packet, 0, len(es))
+	for _, e := range es {
+		p, err := d.readEntry(e)
+		if err != nil {
+			return ps, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+func (d *Decoder) readEntry(e index.Entry) (Packet, error) {
+	f, err := os.Open(filepath.Join(d.dir, e.File))
+	if err != nil {
+		return Packet{}, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, e.Len)
+	if _, err := f.ReadAt(buf, e.Offset); err != nil {
+		return Packet{}, err
+	}
+	return decodePacket(buf, true)
+}
+
 func decodePacket(buffer []byte, data bool) (p Packet, err error) {
 	if len(buffer) < UMIHeaderLen {
 		err = io.ErrShortBuffer
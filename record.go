@@ -0,0 +1,201 @@
+package pdh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Record is a flattened, serialization-friendly view of a Packet's
+// header fields.
+type Record struct {
+	Timestamp time.Time
+	Code      [UMICodeLen]byte
+	State     UMIPacketState
+	Type      UMIValueType
+	Orbit     uint32
+	Len       uint16
+	Unit      uint16
+}
+
+func NewRecord(p Packet) Record {
+	return Record{
+		Timestamp: p.Timestamp(),
+		Code:      p.Code,
+		State:     p.State,
+		Type:      p.Type,
+		Orbit:     p.Orbit,
+		Len:       p.Len,
+		Unit:      p.Unit,
+	}
+}
+
+// Coze mirrors the shape of rt.Coze (start/end time, count and size of a
+// run of packets) without pdh depending on the rt package.
+type Coze struct {
+	Count     uint64
+	Size      uint64
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// RecordWriter writes one Record at a time in some structured format.
+type RecordWriter interface {
+	WriteRecord(Record) error
+}
+
+// CozeWriter writes per-code aggregates in some structured format.
+type CozeWriter interface {
+	WriteCoze(code [UMICodeLen]byte, cz Coze) error
+}
+
+type jsonRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	Code      string    `json:"code"`
+	State     string    `json:"state"`
+	Type      string    `json:"type"`
+	Orbit     uint32    `json:"orbit"`
+	Len       uint16    `json:"len"`
+	Unit      uint16    `json:"unit"`
+}
+
+type jsonCoze struct {
+	Code      string    `json:"code"`
+	Count     uint64    `json:"count"`
+	Size      uint64    `json:"size"`
+	StartTime time.Time `json:"start"`
+	EndTime   time.Time `json:"end"`
+}
+
+// JSONWriter streams newline-delimited JSON objects, one per Record or
+// Coze written.
+type JSONWriter struct {
+	enc *json.Encoder
+}
+
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{enc: json.NewEncoder(w)}
+}
+
+func (jw *JSONWriter) WriteRecord(r Record) error {
+	j := jsonRecord{
+		Timestamp: r.Timestamp.UTC(),
+		Code:      hex.EncodeToString(r.Code[:]),
+		State:     r.State.String(),
+		Type:      r.Type.String(),
+		Orbit:     r.Orbit,
+		Len:       r.Len,
+		Unit:      r.Unit,
+	}
+	return jw.enc.Encode(j)
+}
+
+func (jw *JSONWriter) WriteCoze(code [UMICodeLen]byte, cz Coze) error {
+	j := jsonCoze{
+		Code:      hex.EncodeToString(code[:]),
+		Count:     cz.Count,
+		Size:      cz.Size,
+		StartTime: cz.StartTime.UTC(),
+		EndTime:   cz.EndTime.UTC(),
+	}
+	return jw.enc.Encode(j)
+}
+
+// MsgpackWriter emits a compact MessagePack record per Record or Coze
+// written, in the same field order as JSONWriter.
+type MsgpackWriter struct {
+	w io.Writer
+}
+
+func NewMsgpackWriter(w io.Writer) *MsgpackWriter {
+	return &MsgpackWriter{w: w}
+}
+
+func (mw *MsgpackWriter) WriteRecord(r Record) error {
+	var buf bytes.Buffer
+	writeMsgpackArray(&buf, 7)
+	writeMsgpackStr(&buf, r.Timestamp.UTC().Format(time.RFC3339Nano))
+	writeMsgpackStr(&buf, hex.EncodeToString(r.Code[:]))
+	writeMsgpackStr(&buf, r.State.String())
+	writeMsgpackStr(&buf, r.Type.String())
+	writeMsgpackUint(&buf, uint64(r.Orbit))
+	writeMsgpackUint(&buf, uint64(r.Len))
+	writeMsgpackUint(&buf, uint64(r.Unit))
+	_, err := mw.w.Write(buf.Bytes())
+	return err
+}
+
+func (mw *MsgpackWriter) WriteCoze(code [UMICodeLen]byte, cz Coze) error {
+	var buf bytes.Buffer
+	writeMsgpackArray(&buf, 5)
+	writeMsgpackStr(&buf, hex.EncodeToString(code[:]))
+	writeMsgpackUint(&buf, cz.Count)
+	writeMsgpackUint(&buf, cz.Size)
+	writeMsgpackStr(&buf, cz.StartTime.UTC().Format(time.RFC3339Nano))
+	writeMsgpackStr(&buf, cz.EndTime.UTC().Format(time.RFC3339Nano))
+	_, err := mw.w.Write(buf.Bytes())
+	return err
+}
+
+func writeMsgpackArray(buf *bytes.Buffer, n int) {
+	switch {
+	case n < 16:
+		buf.WriteByte(0x90 | byte(n))
+	default:
+		buf.WriteByte(0xdc)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	}
+}
+
+func writeMsgpackStr(buf *bytes.Buffer, s string) {
+	switch n := len(s); {
+	case n < 32:
+		buf.WriteByte(0xa0 | byte(n))
+	case n < 256:
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(n))
+	default:
+		buf.WriteByte(0xda)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	}
+	buf.WriteString(s)
+}
+
+func writeMsgpackUint(buf *bytes.Buffer, v uint64) {
+	switch {
+	case v < 128:
+		buf.WriteByte(byte(v))
+	case v <= 0xff:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(v))
+	case v <= 0xffff:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(v))
+	case v <= 0xffffffff:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(v))
+	default:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, v)
+	}
+}
+
+// NewRecordWriter builds the RecordWriter/CozeWriter for one of the
+// structured formats ("json" or "msgpack"). It returns ok=false for any
+// other format so callers can fall back to their own text/csv layout.
+func NewRecordWriter(format string, w io.Writer) (writer interface {
+	RecordWriter
+	CozeWriter
+}, ok bool) {
+	switch format {
+	case "json":
+		return NewJSONWriter(w), true
+	case "msgpack":
+		return NewMsgpackWriter(w), true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,74 @@
+package pdh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestMsgpackWriterWriteRecord(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	r := Record{
+		Timestamp: ts,
+		Code:      [UMICodeLen]byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		State:     StateNewValue,
+		Type:      Int32,
+		Orbit:     7,
+		Len:       10,
+		Unit:      3,
+	}
+
+	tsStr := ts.Format(time.RFC3339Nano)
+	var want bytes.Buffer
+	want.WriteByte(0x90 | 7) // fixarray, 7 elements
+	want.WriteByte(0xa0 | byte(len(tsStr)))
+	want.WriteString(tsStr)
+	want.WriteByte(0xa0 | 12) // "aabbccddeeff"
+	want.WriteString("aabbccddeeff")
+	want.WriteByte(0xa0 | 3)
+	want.WriteString("new")
+	want.WriteByte(0xa0 | 4)
+	want.WriteString("long")
+	want.WriteByte(7)  // orbit, fits in a positive fixint
+	want.WriteByte(10) // len
+	want.WriteByte(3)  // unit
+
+	var got bytes.Buffer
+	if err := NewMsgpackWriter(&got).WriteRecord(r); err != nil {
+		t.Fatalf("WriteRecord: %s", err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Fatalf("WriteRecord:\n got  % x\n want % x", got.Bytes(), want.Bytes())
+	}
+}
+
+func TestMsgpackWriterWriteCoze(t *testing.T) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	end := time.Date(2020, 1, 2, 3, 5, 0, 0, time.UTC)
+	cz := Coze{Count: 300, Size: 70000, StartTime: start, EndTime: end}
+	code := [UMICodeLen]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+
+	startStr, endStr := start.Format(time.RFC3339Nano), end.Format(time.RFC3339Nano)
+
+	var want bytes.Buffer
+	want.WriteByte(0x90 | 5) // fixarray, 5 elements
+	want.WriteByte(0xa0 | 12)
+	want.WriteString("010203040506")
+	want.WriteByte(0xcd) // uint16
+	binary.Write(&want, binary.BigEndian, uint16(300))
+	want.WriteByte(0xce) // uint32
+	binary.Write(&want, binary.BigEndian, uint32(70000))
+	want.WriteByte(0xa0 | byte(len(startStr)))
+	want.WriteString(startStr)
+	want.WriteByte(0xa0 | byte(len(endStr)))
+	want.WriteString(endStr)
+
+	var got bytes.Buffer
+	if err := NewMsgpackWriter(&got).WriteCoze(code, cz); err != nil {
+		t.Fatalf("WriteCoze: %s", err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Fatalf("WriteCoze:\n got  % x\n want % x", got.Bytes(), want.Bytes())
+	}
+}